@@ -0,0 +1,132 @@
+// Package filter lets operators restrict and reshape what AddMetric
+// actually ships to a sink: allow/deny glob patterns on the fully
+// qualified "origin.name", per-event-type toggles, and small tag
+// rewriting rules (drop, rename, add static tags).
+package filter
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+// TagRule rewrites a single tag as metrics pass through a Filter. Set
+// Drop to remove the tag entirely, or Rename to keep it under a
+// different key. A rule with neither set is a no-op.
+type TagRule struct {
+	Tag    string
+	Drop   bool
+	Rename string
+}
+
+// Filter decides whether an envelope should be shipped at all, and
+// how its tags should be rewritten before it is. A nil *Filter allows
+// everything and rewrites nothing.
+type Filter struct {
+	// Allow, if non-empty, restricts metrics to those whose
+	// "origin.name" matches at least one of these glob patterns
+	// (see path/filepath.Match for syntax).
+	Allow []string
+	// Deny drops any metric whose "origin.name" matches one of these
+	// glob patterns. Checked after Allow.
+	Deny []string
+	// EventTypes, if non-empty, restricts metrics to these event
+	// types. An empty set allows every type.
+	EventTypes map[events.Envelope_EventType]bool
+
+	// TagRules are applied, in order, to every tag on a metric that
+	// survives Allow/Deny/EventTypes filtering.
+	TagRules []TagRule
+	// StaticTags are appended to every tag set that survives
+	// filtering, e.g. "environment=prod".
+	StaticTags []string
+}
+
+// Allows reports whether an envelope should be shipped at all.
+func (f *Filter) Allows(envelope *events.Envelope) bool {
+	if f == nil {
+		return true
+	}
+
+	if len(f.EventTypes) > 0 && !f.EventTypes[envelope.GetEventType()] {
+		return false
+	}
+
+	name := fullyQualifiedName(envelope)
+
+	if len(f.Allow) > 0 && !matchesAny(f.Allow, name) {
+		return false
+	}
+	if matchesAny(f.Deny, name) {
+		return false
+	}
+
+	return true
+}
+
+// RewriteTags applies TagRules and StaticTags to a "key=value" tag
+// set, returning a new slice; the input is left untouched. A nil
+// Filter returns tags itself.
+func (f *Filter) RewriteTags(tags []string) []string {
+	if f == nil {
+		return tags
+	}
+
+	out := make([]string, 0, len(tags)+len(f.StaticTags))
+	for _, tag := range tags {
+		key, value, ok := splitTag(tag)
+		if !ok {
+			out = append(out, tag)
+			continue
+		}
+		if rewritten, keep := f.applyTagRules(key, value); keep {
+			out = append(out, rewritten)
+		}
+	}
+
+	return append(out, f.StaticTags...)
+}
+
+func (f *Filter) applyTagRules(key, value string) (string, bool) {
+	for _, rule := range f.TagRules {
+		if rule.Tag != key {
+			continue
+		}
+		if rule.Drop {
+			return "", false
+		}
+		if rule.Rename != "" {
+			key = rule.Rename
+		}
+	}
+	return key + "=" + value, true
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func splitTag(tag string) (key string, value string, ok bool) {
+	idx := strings.IndexByte(tag, '=')
+	if idx < 0 {
+		return "", "", false
+	}
+	return tag[:idx], tag[idx+1:], true
+}
+
+func fullyQualifiedName(envelope *events.Envelope) string {
+	switch envelope.GetEventType() {
+	case events.Envelope_ValueMetric:
+		return envelope.GetOrigin() + "." + envelope.GetValueMetric().GetName()
+	case events.Envelope_CounterEvent:
+		return envelope.GetOrigin() + "." + envelope.GetCounterEvent().GetName()
+	default:
+		return envelope.GetOrigin()
+	}
+}
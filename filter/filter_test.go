@@ -0,0 +1,136 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/golang/protobuf/proto"
+)
+
+func valueMetricEnvelope(origin, name string) *events.Envelope {
+	return &events.Envelope{
+		Origin:    proto.String(origin),
+		EventType: events.Envelope_ValueMetric.Enum(),
+		ValueMetric: &events.ValueMetric{
+			Name:  proto.String(name),
+			Value: proto.Float64(1),
+		},
+	}
+}
+
+func TestFilterAllows(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter *Filter
+		env    *events.Envelope
+		want   bool
+	}{
+		{
+			name:   "nil filter allows everything",
+			filter: nil,
+			env:    valueMetricEnvelope("rep", "CapacityRemainingMemory"),
+			want:   true,
+		},
+		{
+			name:   "allowlist match",
+			filter: &Filter{Allow: []string{"rep.*"}},
+			env:    valueMetricEnvelope("rep", "CapacityRemainingMemory"),
+			want:   true,
+		},
+		{
+			name:   "allowlist miss",
+			filter: &Filter{Allow: []string{"rep.*"}},
+			env:    valueMetricEnvelope("dea_logging_agent", "some_metric"),
+			want:   false,
+		},
+		{
+			name:   "denylist match",
+			filter: &Filter{Deny: []string{"noisy.*"}},
+			env:    valueMetricEnvelope("noisy", "chatter"),
+			want:   false,
+		},
+		{
+			name:   "deny wins over allow",
+			filter: &Filter{Allow: []string{"rep.*"}, Deny: []string{"rep.Noisy*"}},
+			env:    valueMetricEnvelope("rep", "NoisyMetric"),
+			want:   false,
+		},
+		{
+			name: "event type toggle excludes other types",
+			filter: &Filter{
+				EventTypes: map[events.Envelope_EventType]bool{events.Envelope_CounterEvent: true},
+			},
+			env:  valueMetricEnvelope("rep", "CapacityRemainingMemory"),
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Allows(tt.env); got != tt.want {
+				t.Errorf("Allows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterRewriteTags(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter *Filter
+		tags   []string
+		want   []string
+	}{
+		{
+			name:   "nil filter passes through",
+			filter: nil,
+			tags:   []string{"job=router", "index=0"},
+			want:   []string{"job=router", "index=0"},
+		},
+		{
+			name:   "drop tag",
+			filter: &Filter{TagRules: []TagRule{{Tag: "index", Drop: true}}},
+			tags:   []string{"job=router", "index=0"},
+			want:   []string{"job=router"},
+		},
+		{
+			name:   "rename tag",
+			filter: &Filter{TagRules: []TagRule{{Tag: "job", Rename: "component"}}},
+			tags:   []string{"job=router"},
+			want:   []string{"component=router"},
+		},
+		{
+			name:   "add static tags",
+			filter: &Filter{StaticTags: []string{"environment=prod"}},
+			tags:   []string{"job=router"},
+			want:   []string{"job=router", "environment=prod"},
+		},
+		{
+			name:   "malformed tag passes through untouched",
+			filter: &Filter{TagRules: []TagRule{{Tag: "job", Drop: true}}},
+			tags:   []string{"nope"},
+			want:   []string{"nope"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.filter.RewriteTags(tt.tags)
+			if !equalTagSlices(got, tt.want) {
+				t.Errorf("RewriteTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func equalTagSlices(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
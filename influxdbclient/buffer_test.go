@@ -0,0 +1,46 @@
+package influxdbclient
+
+import (
+	"testing"
+)
+
+func TestSetMaxBufferedSeriesEvictsOldest(t *testing.T) {
+	c := New("http://influx", "db", "", "", "", "deployment", "1.2.3.4")
+	c.SetMaxBufferedSeries(2)
+
+	c.AddMetric(valueMetricEnvelope("origin", "first", "job", "deployment", "0", "1.2.3.4", 1000000000, 1))
+	c.AddMetric(valueMetricEnvelope("origin", "second", "job", "deployment", "0", "1.2.3.4", 1000000000, 2))
+	c.AddMetric(valueMetricEnvelope("origin", "third", "job", "deployment", "0", "1.2.3.4", 1000000000, 3))
+
+	if len(c.metricPoints) != 2 {
+		t.Fatalf("expected 2 buffered series after eviction, got %d", len(c.metricPoints))
+	}
+
+	for key := range c.metricPoints {
+		if key.name == "origin.first" {
+			t.Errorf("expected the oldest series (origin.first) to have been evicted, still present: %v", c.metricPoints)
+		}
+	}
+
+	_, droppedPoints := c.counters()
+	if droppedPoints != 1 {
+		t.Errorf("expected droppedPoints=1, got %d", droppedPoints)
+	}
+}
+
+func TestSetMaxBufferedSeriesZeroDisablesBound(t *testing.T) {
+	c := New("http://influx", "db", "", "", "", "deployment", "1.2.3.4")
+	c.SetMaxBufferedSeries(0)
+
+	for i, name := range []string{"a", "b", "c", "d", "e"} {
+		c.AddMetric(valueMetricEnvelope("origin", name, "job", "deployment", "0", "1.2.3.4", int64(i)*1000000000, float64(i)))
+	}
+
+	if len(c.metricPoints) != 5 {
+		t.Fatalf("expected all 5 series to stay buffered with the bound disabled, got %d series", len(c.metricPoints))
+	}
+	_, droppedPoints := c.counters()
+	if droppedPoints != 0 {
+		t.Errorf("expected no drops with the bound disabled, got %d", droppedPoints)
+	}
+}
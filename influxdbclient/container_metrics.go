@@ -0,0 +1,76 @@
+package influxdbclient
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+// containerKey identifies a single application instance, so that only
+// the newest ContainerMetric envelope received for that instance is
+// kept around between flushes.
+type containerKey struct {
+	applicationId string
+	instanceIndex int32
+}
+
+// addContainerMetric keeps the latest ContainerMetric envelope per
+// (application_id, instance_index), discarding anything older than
+// what is already stored, since only the newest snapshot of a
+// container's stats is ever useful.
+func (c *Client) addContainerMetric(envelope *events.Envelope) {
+	cm := envelope.GetContainerMetric()
+	key := containerKey{
+		applicationId: cm.GetApplicationId(),
+		instanceIndex: cm.GetInstanceIndex(),
+	}
+
+	if existing, ok := c.containerMetrics[key]; ok && existing.GetTimestamp() > envelope.GetTimestamp() {
+		return
+	}
+
+	c.containerMetrics[key] = envelope
+}
+
+// containerMetricEntries materializes the latest tracked snapshot of
+// every container into metricPoints-shaped entries, one measurement
+// per container stat, tagged with application_id and instance_index.
+func (c *Client) containerMetricEntries() map[metricKey]metricValue {
+	entries := make(map[metricKey]metricValue, len(c.containerMetrics)*5)
+
+	for key, envelope := range c.containerMetrics {
+		cm := envelope.GetContainerMetric()
+		tags := c.filter.RewriteTags([]string{
+			fmt.Sprintf("application_id=%s", key.applicationId),
+			fmt.Sprintf("instance_index=%d", key.instanceIndex),
+		})
+		point := Point{
+			Timestamp: envelope.GetTimestamp() / int64(time.Second),
+			Value:     0,
+		}
+
+		stats := map[string]float64{
+			"cpu_percentage":     cm.GetCpuPercentage(),
+			"memory_bytes":       float64(cm.GetMemoryBytes()),
+			"disk_bytes":         float64(cm.GetDiskBytes()),
+			"memory_bytes_quota": float64(cm.GetMemoryBytesQuota()),
+			"disk_bytes_quota":   float64(cm.GetDiskBytesQuota()),
+		}
+
+		for name, value := range stats {
+			point.Value = value
+			mKey := metricKey{
+				name:          name,
+				applicationId: key.applicationId,
+				instanceIndex: key.instanceIndex,
+			}
+			entries[mKey] = metricValue{
+				tags:   tags,
+				points: []Point{point},
+			}
+		}
+	}
+
+	return entries
+}
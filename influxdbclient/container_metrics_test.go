@@ -0,0 +1,73 @@
+package influxdbclient
+
+import (
+	"testing"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/golang/protobuf/proto"
+)
+
+func containerMetricEnvelope(applicationId string, instanceIndex int32, timestamp int64, cpuPercentage float64) *events.Envelope {
+	return &events.Envelope{
+		EventType: events.Envelope_ContainerMetric.Enum(),
+		Timestamp: proto.Int64(timestamp),
+		ContainerMetric: &events.ContainerMetric{
+			ApplicationId: proto.String(applicationId),
+			InstanceIndex: proto.Int32(instanceIndex),
+			CpuPercentage: proto.Float64(cpuPercentage),
+		},
+	}
+}
+
+func TestAddContainerMetricLastWriteWins(t *testing.T) {
+	c := New("http://influx", "db", "", "", "", "deployment", "1.2.3.4")
+
+	c.addContainerMetric(containerMetricEnvelope("app-1", 0, 2000000000, 10))
+	c.addContainerMetric(containerMetricEnvelope("app-1", 0, 1000000000, 99))
+
+	key := containerKey{applicationId: "app-1", instanceIndex: 0}
+	got := c.containerMetrics[key]
+	if got == nil {
+		t.Fatalf("expected a stored envelope for %v", key)
+	}
+	if got.GetContainerMetric().GetCpuPercentage() != 10 {
+		t.Errorf("expected the newer envelope (cpu=10) to win, got cpu=%v", got.GetContainerMetric().GetCpuPercentage())
+	}
+}
+
+func TestAddContainerMetricKeyedByApplicationAndInstance(t *testing.T) {
+	c := New("http://influx", "db", "", "", "", "deployment", "1.2.3.4")
+
+	c.addContainerMetric(containerMetricEnvelope("app-1", 0, 1000000000, 10))
+	c.addContainerMetric(containerMetricEnvelope("app-1", 1, 1000000000, 20))
+	c.addContainerMetric(containerMetricEnvelope("app-2", 0, 1000000000, 30))
+
+	if len(c.containerMetrics) != 3 {
+		t.Fatalf("expected 3 distinct container entries, got %d", len(c.containerMetrics))
+	}
+}
+
+func TestContainerMetricEntriesOnePerStat(t *testing.T) {
+	c := New("http://influx", "db", "", "", "", "deployment", "1.2.3.4")
+	c.addContainerMetric(containerMetricEnvelope("app-1", 2, 1000000000, 42))
+
+	entries := c.containerMetricEntries()
+	if len(entries) != 5 {
+		t.Fatalf("expected 5 stat entries, got %d", len(entries))
+	}
+
+	key := metricKey{name: "cpu_percentage", applicationId: "app-1", instanceIndex: 2}
+	mVal, ok := entries[key]
+	if !ok {
+		t.Fatalf("expected an entry for %v, got %v", key, entries)
+	}
+	if len(mVal.points) != 1 || mVal.points[0].Value != 42 {
+		t.Errorf("expected cpu_percentage=42, got %v", mVal.points)
+	}
+	if !hasValue(mVal.tags, "application_id=app-1") {
+		t.Errorf("expected application_id tag, got %v", mVal.tags)
+	}
+	if !hasValue(mVal.tags, "instance_index=2") {
+		t.Errorf("expected instance_index tag, got %v", mVal.tags)
+	}
+}
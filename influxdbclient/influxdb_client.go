@@ -5,34 +5,90 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"errors"
 	"log"
 
 	"github.com/cloudfoundry/sonde-go/events"
+
+	"github.com/18F/influxdb-firehose-nozzle/filter"
+)
+
+// Version selects the InfluxDB wire protocol a Client speaks.
+type Version int
+
+const (
+	// Version1 writes to the legacy /write?db= endpoint using the
+	// nozzle's original user/password fields. This is the default
+	// so existing callers of New keep working unchanged.
+	Version1 Version = iota
+	// Version2 writes to /api/v2/write using token auth and an
+	// async, batched writer. Construct with NewV2.
+	Version2
 )
 
+// defaultMaxBufferedSeries bounds how many distinct series New and
+// NewV2 will buffer between successful PostMetrics calls, so that a
+// persistently unreachable InfluxDB can't grow metricPoints without
+// bound. Override with SetMaxBufferedSeries.
+const defaultMaxBufferedSeries = 20000
+
 type Client struct {
 	url                   string
 	database              string
 	user                  string
 	password              string
+	version               Version
+	org                   string
+	bucket                string
+	token                 string
 	metricPoints          map[metricKey]metricValue
+	containerMetrics      map[containerKey]*events.Envelope
 	prefix                string
 	deployment            string
 	ip                    string
 	totalMessagesReceived uint64
 	totalMetricsSent      uint64
+
+	// seriesOrder records the order in which series were first added
+	// to metricPoints, so that once maxBufferedSeries is reached the
+	// oldest series can be evicted to make room for new ones.
+	seriesOrder       []metricKey
+	maxBufferedSeries int
+	droppedPoints     uint64
+
+	filter *filter.Filter
+
+	httpClient *http.Client
+
+	// v2-only: async batching writer state. Unused in Version1 mode.
+	// mu additionally guards totalMetricsSent and droppedPoints, since
+	// both are written from the async writer goroutine as well as
+	// whatever goroutine calls AddMetric/PostMetrics.
+	batchSize       int
+	maxPendingLines int
+	flushInterval   time.Duration
+	mu              sync.Mutex
+	pending         [][]byte
+	flushTrigger    chan struct{}
+	flushReqCh      chan chan error
+	closeCh         chan struct{}
+	closeOnce       sync.Once
+	wg              sync.WaitGroup
 }
 
 type metricKey struct {
-	eventType  events.Envelope_EventType
-	name       string
-	deployment string
-	job        string
-	index      string
-	ip         string
+	eventType     events.Envelope_EventType
+	name          string
+	deployment    string
+	job           string
+	index         string
+	ip            string
+	applicationId string
+	instanceIndex int32
 }
 
 type metricValue struct {
@@ -55,27 +111,57 @@ type Point struct {
 
 func New(url string, database string, user string, password string, prefix string, deployment string, ip string) *Client {
 	return &Client{
-		url:          url,
-		database:     database,
-		user:         user,
-		password:     password,
-		metricPoints: make(map[metricKey]metricValue),
-		prefix:       prefix,
-		deployment:   deployment,
-		ip:           ip,
+		url:               url,
+		database:          database,
+		user:              user,
+		password:          password,
+		version:           Version1,
+		metricPoints:      make(map[metricKey]metricValue),
+		containerMetrics:  make(map[containerKey]*events.Envelope),
+		prefix:            prefix,
+		deployment:        deployment,
+		ip:                ip,
+		httpClient:        &http.Client{Timeout: defaultWriteTimeout},
+		maxBufferedSeries: defaultMaxBufferedSeries,
 	}
 }
 
+// SetMaxBufferedSeries bounds how many distinct series AddMetric will
+// buffer between successful PostMetrics calls. Once the limit is
+// reached, the oldest buffered series is evicted to make room and its
+// points are counted in the droppedPoints internal metric. A value
+// <= 0 disables the bound.
+func (c *Client) SetMaxBufferedSeries(n int) {
+	c.maxBufferedSeries = n
+}
+
+// SetFilter installs f as the Client's metric filter, restricting
+// which envelopes AddMetric accepts and how their tags are rewritten.
+// A nil filter (the default) allows everything through unchanged.
+func (c *Client) SetFilter(f *filter.Filter) {
+	c.filter = f
+}
+
 func (c *Client) AlertSlowConsumerError() {
 	c.addInternalMetric("slowConsumerAlert", uint64(1))
 }
 
 func (c *Client) AddMetric(envelope *events.Envelope) {
 	c.totalMessagesReceived++
-	if envelope.GetEventType() != events.Envelope_ValueMetric && envelope.GetEventType() != events.Envelope_CounterEvent {
+
+	if !c.filter.Allows(envelope) {
 		return
 	}
 
+	switch envelope.GetEventType() {
+	case events.Envelope_ValueMetric, events.Envelope_CounterEvent:
+		c.addValueMetric(envelope)
+	case events.Envelope_ContainerMetric:
+		c.addContainerMetric(envelope)
+	}
+}
+
+func (c *Client) addValueMetric(envelope *events.Envelope) {
 	key := metricKey{
 		eventType:  envelope.GetEventType(),
 		name:       getName(envelope),
@@ -85,10 +171,13 @@ func (c *Client) AddMetric(envelope *events.Envelope) {
 		ip:         envelope.GetIp(),
 	}
 
-	mVal := c.metricPoints[key]
+	mVal, exists := c.metricPoints[key]
+	if !exists {
+		c.admitSeries(key)
+	}
 	value := getValue(envelope)
 
-	mVal.tags = getTags(envelope)
+	mVal.tags = c.filter.RewriteTags(getTags(envelope))
 	mVal.points = append(mVal.points, Point{
 		Timestamp: envelope.GetTimestamp() / int64(time.Second),
 		Value:     value,
@@ -97,32 +186,133 @@ func (c *Client) AddMetric(envelope *events.Envelope) {
 	c.metricPoints[key] = mVal
 }
 
+// admitSeries records a newly-seen series in seriesOrder, evicting
+// the oldest buffered series first if maxBufferedSeries has been
+// reached.
+func (c *Client) admitSeries(key metricKey) {
+	if c.maxBufferedSeries > 0 && len(c.metricPoints) >= c.maxBufferedSeries {
+		c.evictOldestSeries()
+	}
+	c.seriesOrder = append(c.seriesOrder, key)
+}
+
+func (c *Client) evictOldestSeries() {
+	for len(c.seriesOrder) > 0 {
+		oldest := c.seriesOrder[0]
+		c.seriesOrder = c.seriesOrder[1:]
+
+		if mVal, ok := c.metricPoints[oldest]; ok {
+			c.addDroppedPoints(uint64(len(mVal.points)))
+			delete(c.metricPoints, oldest)
+			return
+		}
+	}
+}
+
+// addDroppedPoints and addTotalMetricsSent serialize access to
+// droppedPoints/totalMetricsSent via mu, since both are also written
+// by the async V2 writer goroutine (see v2.go) independently of
+// whatever goroutine is calling AddMetric/PostMetrics.
+func (c *Client) addDroppedPoints(n uint64) {
+	c.mu.Lock()
+	c.droppedPoints += n
+	c.mu.Unlock()
+}
+
+func (c *Client) addTotalMetricsSent(n uint64) {
+	c.mu.Lock()
+	c.totalMetricsSent += n
+	c.mu.Unlock()
+}
+
+func (c *Client) counters() (totalMetricsSent, droppedPoints uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.totalMetricsSent, c.droppedPoints
+}
+
+// PostMetrics flushes everything buffered by AddMetric to InfluxDB. In
+// Version2 mode this is a thin wrapper that hands the formatted lines
+// to the async writer and blocks until it has flushed them; see
+// postMetricsV2 in v2.go.
 func (c *Client) PostMetrics() error {
-	url := c.seriesURL()
+	if c.version == Version2 {
+		return c.postMetricsV2()
+	}
+	return c.postMetricsV1()
+}
 
+func (c *Client) postMetricsV1() error {
 	c.populateInternalMetrics()
 	numMetrics := len(c.metricPoints)
 	log.Printf("Posting %d metrics", numMetrics)
 
 	seriesBytes, metricsCount := c.formatMetrics()
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(seriesBytes))
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
+	if err := c.sendV1(seriesBytes); err != nil {
 		return err
 	}
 
-	defer resp.Body.Close()
-	if resp.StatusCode >= 300 || resp.StatusCode < 200 {
-		return fmt.Errorf("InfluxDB request returned HTTP response: %s", resp.Status)
-	}
-
-	c.totalMetricsSent += metricsCount
+	c.addTotalMetricsSent(metricsCount)
 	c.metricPoints = make(map[metricKey]metricValue)
+	c.seriesOrder = nil
 
 	return nil
 }
 
+// sendV1 POSTs line-protocol bytes to the legacy /write endpoint,
+// retrying on 429 and 5xx responses with exponential backoff honoring
+// Retry-After. The buffer is only cleared by the caller once this
+// returns successfully.
+func (c *Client) sendV1(body []byte) error {
+	url := c.seriesURL()
+
+	wait := retryBaseWait
+	var retryAfter time.Duration
+	var lastErr error
+	for attempt := 0; attempt <= maxWriteAttempts; attempt++ {
+		if attempt > 0 {
+			sleep := jitter(wait)
+			if sleep < retryAfter {
+				sleep = retryAfter
+			}
+			time.Sleep(sleep)
+			wait = nextBackoff(wait)
+		}
+		retryAfter = 0
+
+		req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		if c.user != "" {
+			req.SetBasicAuth(c.user, c.password)
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+
+		lastErr = fmt.Errorf("InfluxDB request returned HTTP response: %s", resp.Status)
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+			return lastErr
+		}
+		if ra := parseRetryAfter(resp.Header.Get("Retry-After")); ra > 0 {
+			wait = ra
+			retryAfter = ra
+		}
+	}
+
+	return lastErr
+}
+
 func (c *Client) seriesURL() string {
 	url := fmt.Sprintf("%s/write?db=%s", c.url, c.database)
 	log.Print("Using the following influx URL " + url)
@@ -130,8 +320,11 @@ func (c *Client) seriesURL() string {
 }
 
 func (c *Client) populateInternalMetrics() {
+	totalMetricsSent, droppedPoints := c.counters()
+
 	c.addInternalMetric("totalMessagesReceived", c.totalMessagesReceived)
-	c.addInternalMetric("totalMetricsSent", c.totalMetricsSent)
+	c.addInternalMetric("totalMetricsSent", totalMetricsSent)
+	c.addInternalMetric("droppedPoints", droppedPoints)
 
 	if !c.containsSlowConsumerAlert() {
 		c.addInternalMetric("slowConsumerAlert", uint64(0))
@@ -148,23 +341,46 @@ func (c *Client) containsSlowConsumerAlert() bool {
 	return ok
 }
 
+// formatMetrics renders every buffered series as line protocol, one
+// line per timestamped Point (line protocol has no way to express
+// multiple values for the same field on one line). It returns the
+// number of lines written, which is also the number of points sent.
 func (c *Client) formatMetrics() ([]byte, uint64) {
 	var buffer bytes.Buffer
+	var lineCount uint64
 
+	all := make(map[metricKey]metricValue, len(c.metricPoints)+len(c.containerMetrics)*5)
 	for key, mVal := range c.metricPoints {
-		buffer.WriteString(c.prefix + key.name)
-		buffer.WriteString(",")
-		buffer.WriteString(formatTags(mVal.tags))
-		buffer.WriteString(" ")
-		buffer.WriteString(formatValues(mVal.points))
-		buffer.WriteString(" ")
-		buffer.WriteString(formatTimestamp(mVal.points))
-		buffer.WriteString("\n")
+		all[key] = mVal
+	}
+	for key, mVal := range c.containerMetricEntries() {
+		all[key] = mVal
+	}
+
+	for key, mVal := range all {
+		measurement := escapeMeasurement(c.prefix + key.name)
+		tagset := formatTags(mVal.tags)
+
+		for _, point := range mVal.points {
+			buffer.WriteString(measurement)
+			if tagset != "" {
+				buffer.WriteString(",")
+				buffer.WriteString(tagset)
+			}
+			buffer.WriteString(" value=")
+			buffer.WriteString(strconv.FormatFloat(point.Value, 'f', -1, 64))
+			buffer.WriteString(" ")
+			buffer.WriteString(formatTimestamp(point))
+			buffer.WriteString("\n")
+			lineCount++
+		}
 	}
 
-	return buffer.Bytes(), uint64(len(c.metricPoints))
+	return buffer.Bytes(), lineCount
 }
 
+// formatTags joins "key=value" tags into a line-protocol tag set,
+// escaping each key and value per the line protocol spec.
 func formatTags(tags []string) string {
 	var newTags string
 	for index, tag := range tags {
@@ -172,29 +388,38 @@ func formatTags(tags []string) string {
 			newTags += ","
 		}
 
-		newTags += tag
+		newTags += escapeTag(tag)
 	}
 	return newTags
 }
 
-func formatValues(points []Point) string {
-	var newPoints string
-	for index, point := range points {
-		if index > 0 {
-			newPoints += ","
-		}
+func formatTimestamp(point Point) string {
+	return strconv.FormatInt(point.Timestamp*1000*1000*1000, 10)
+}
 
-		newPoints += "value=" + strconv.FormatFloat(point.Value, 'f', -1, 64)
+// escapeTag escapes the key and value of a single "key=value" tag.
+// Tags that don't contain "=" (which shouldn't happen given how this
+// package builds them) are escaped as-is.
+func escapeTag(tag string) string {
+	idx := strings.IndexByte(tag, '=')
+	if idx < 0 {
+		return escapeTagComponent(tag)
 	}
-	return newPoints
+	return escapeTagComponent(tag[:idx]) + "=" + escapeTagComponent(tag[idx+1:])
 }
 
-func formatTimestamp(points []Point) string {
-	if len(points) > 0 {
-		return strconv.FormatInt(points[0].Timestamp*1000*1000*1000, 10)
-	} else {
-		return strconv.FormatInt(time.Now().Unix()*1000*1000*1000, 10)
-	}
+var tagEscaper = strings.NewReplacer(`\`, `\\`, `,`, `\,`, `=`, `\=`, ` `, `\ `)
+
+func escapeTagComponent(s string) string {
+	return tagEscaper.Replace(s)
+}
+
+var measurementEscaper = strings.NewReplacer(`\`, `\\`, `,`, `\,`, ` `, `\ `)
+
+// escapeMeasurement escapes a measurement name per the line protocol
+// spec: commas and spaces, but not "=", need escaping.
+func escapeMeasurement(name string) string {
+	return measurementEscaper.Replace(name)
 }
 
 func (c *Client) addInternalMetric(name string, value uint64) {
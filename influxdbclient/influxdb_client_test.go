@@ -0,0 +1,150 @@
+package influxdbclient
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/golang/protobuf/proto"
+)
+
+func valueMetricEnvelope(origin, name, job, deployment, index, ip string, timestamp int64, value float64) *events.Envelope {
+	return &events.Envelope{
+		Origin:     proto.String(origin),
+		EventType:  events.Envelope_ValueMetric.Enum(),
+		Timestamp:  proto.Int64(timestamp),
+		Job:        proto.String(job),
+		Deployment: proto.String(deployment),
+		Index:      proto.String(index),
+		Ip:         proto.String(ip),
+		ValueMetric: &events.ValueMetric{
+			Name:  proto.String(name),
+			Value: proto.Float64(value),
+		},
+	}
+}
+
+func TestEscapeMeasurement(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "rep.CapacityRemaining", "rep.CapacityRemaining"},
+		{"space", "job name", `job\ name`},
+		{"comma", "job,name", `job\,name`},
+		{"equals left alone", "job=name", "job=name"},
+		{"backslash", `job\name`, `job\\name`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := escapeMeasurement(tt.in); got != tt.want {
+				t.Errorf("escapeMeasurement(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatTags(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []string
+		want string
+	}{
+		{"empty", nil, ""},
+		{"single", []string{"job=router"}, "job=router"},
+		{"multiple", []string{"job=router", "index=0"}, "job=router,index=0"},
+		{"space in value", []string{"job=api server"}, `job=api\ server`},
+		{"comma in value", []string{"job=api,server"}, `job=api\,server`},
+		{"equals in value", []string{"job=a=b"}, `job=a\=b`},
+		{"backslash in value", []string{`job=a\b`}, `job=a\\b`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatTags(tt.tags); got != tt.want {
+				t.Errorf("formatTags(%v) = %q, want %q", tt.tags, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatMetricsOneLinePerPoint(t *testing.T) {
+	c := New("http://influx", "db", "", "", "", "deployment", "1.2.3.4")
+
+	c.AddMetric(valueMetricEnvelope("origin", "metric", "job", "deployment", "0", "1.2.3.4", 1000000000, 1))
+	c.AddMetric(valueMetricEnvelope("origin", "metric", "job", "deployment", "0", "1.2.3.4", 2000000000, 2))
+
+	body, count := c.formatMetrics()
+	lines := nonEmptyLines(string(body))
+
+	var metricLines []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "origin.metric,") {
+			metricLines = append(metricLines, line)
+		}
+	}
+
+	if len(metricLines) != 2 {
+		t.Fatalf("expected 2 lines for origin.metric, got %d: %v", len(metricLines), metricLines)
+	}
+
+	if !hasValue(metricLines, "value=1 ") {
+		t.Errorf("expected a line with value=1, got %v", metricLines)
+	}
+	if !hasValue(metricLines, "value=2 ") {
+		t.Errorf("expected a line with value=2, got %v", metricLines)
+	}
+	if count < 2 {
+		t.Errorf("expected formatMetrics to report at least 2 points, got %d", count)
+	}
+}
+
+func TestFormatMetricsEscapesPathologicalNames(t *testing.T) {
+	c := New("http://influx", "db", "", "", "", "deployment", "1.2.3.4")
+
+	c.AddMetric(valueMetricEnvelope("my origin", "my metric, name", "job with spaces", "deployment", "0", "1.2.3.4", 1000000000, 42))
+
+	body, _ := c.formatMetrics()
+	line := firstLineContaining(string(body), "value=42 ")
+	if line == "" {
+		t.Fatalf("expected a line with value=42, got body: %s", body)
+	}
+
+	wantMeasurement := `my\ origin.my\ metric\,\ name`
+	if !strings.HasPrefix(line, wantMeasurement+",") {
+		t.Errorf("expected line to start with escaped measurement %q, got %q", wantMeasurement, line)
+	}
+	if !strings.Contains(line, `job=job\ with\ spaces`) {
+		t.Errorf("expected job tag to be escaped, got %q", line)
+	}
+}
+
+func hasValue(lines []string, substr string) bool {
+	for _, line := range lines {
+		if strings.Contains(line, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func nonEmptyLines(s string) []string {
+	var out []string
+	for _, line := range strings.Split(s, "\n") {
+		if line != "" {
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+func firstLineContaining(s, substr string) string {
+	for _, line := range nonEmptyLines(s) {
+		if strings.Contains(line, substr) {
+			return line
+		}
+	}
+	return ""
+}
@@ -0,0 +1,358 @@
+package influxdbclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+const (
+	defaultBatchSize       = 5000
+	defaultFlushInterval   = 10 * time.Second
+	defaultWriteTimeout    = 30 * time.Second
+	defaultMaxPendingLines = 50000
+	maxWriteAttempts       = 5
+	retryBaseWait          = 500 * time.Millisecond
+	retryMaxWait           = 30 * time.Second
+
+	// defaultFlushTimeout bounds how long postMetricsV2's Flush call
+	// waits by default. A single flush can take up to maxWriteAttempts+1
+	// HTTP round trips, each up to defaultWriteTimeout, separated by
+	// backoff sleeps of up to retryMaxWait (longer only when a server's
+	// Retry-After asks for more): sharing defaultWriteTimeout
+	// itself here would let Flush time out while the writer goroutine is
+	// still legitimately retrying in the background.
+	defaultFlushTimeout = time.Duration(maxWriteAttempts+1) * (defaultWriteTimeout + retryMaxWait)
+)
+
+// NewV2 builds a Client that writes to an InfluxDB 2.x server using
+// token auth and org/bucket instead of a database name. Points handed
+// to AddMetric are buffered as in Version1 mode until PostMetrics
+// formats them and queues the lines for a dedicated goroutine, which
+// writes them to /api/v2/write?org=<org>&bucket=<bucket>&precision=ns.
+// That goroutine flushes its queue whenever batchSize lines have
+// accumulated or flushInterval elapses, whichever comes first. Call
+// Close when the nozzle shuts down to flush any lines still queued and
+// stop the goroutine; points added since the last PostMetrics call are
+// not queued, so Close does not write them.
+func NewV2(url, org, bucket, token, prefix, deployment, ip string, batchSize int, flushInterval time.Duration) *Client {
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	if flushInterval <= 0 {
+		flushInterval = defaultFlushInterval
+	}
+
+	c := &Client{
+		url:               url,
+		version:           Version2,
+		org:               org,
+		bucket:            bucket,
+		token:             token,
+		metricPoints:      make(map[metricKey]metricValue),
+		containerMetrics:  make(map[containerKey]*events.Envelope),
+		prefix:            prefix,
+		deployment:        deployment,
+		ip:                ip,
+		httpClient:        &http.Client{Timeout: defaultWriteTimeout},
+		batchSize:         batchSize,
+		maxPendingLines:   defaultMaxPendingLines,
+		flushInterval:     flushInterval,
+		flushTrigger:      make(chan struct{}, 1),
+		flushReqCh:        make(chan chan error),
+		closeCh:           make(chan struct{}),
+		maxBufferedSeries: defaultMaxBufferedSeries,
+	}
+
+	c.wg.Add(1)
+	go c.runAsyncWriter()
+
+	return c
+}
+
+// SetMaxPendingLines bounds how many line-protocol lines the V2 async
+// writer will buffer between successful flushes. Once the limit is
+// reached, the oldest pending lines are evicted to make room and
+// counted in the droppedPoints internal metric. A value <= 0 disables
+// the bound. Mirrors SetMaxBufferedSeries, which bounds the series
+// buffered by AddMetric.
+func (c *Client) SetMaxPendingLines(n int) {
+	c.mu.Lock()
+	c.maxPendingLines = n
+	c.mu.Unlock()
+}
+
+// postMetricsV2 formats whatever AddMetric has buffered, hands it to
+// the async writer, and blocks until that batch has been flushed.
+func (c *Client) postMetricsV2() error {
+	c.populateInternalMetrics()
+
+	seriesBytes, _ := c.formatMetrics()
+	for _, line := range splitLines(seriesBytes) {
+		c.enqueueLine(line)
+	}
+	c.metricPoints = make(map[metricKey]metricValue)
+	c.seriesOrder = nil
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultFlushTimeout)
+	defer cancel()
+	return c.Flush(ctx)
+}
+
+// enqueueLine appends a single line-protocol line to the pending
+// write buffer, waking the writer early if batchSize is reached. If
+// maxPendingLines is exceeded, the oldest pending lines are evicted
+// and counted in droppedPoints so a persistently unreachable InfluxDB
+// can't grow the buffer without bound.
+func (c *Client) enqueueLine(line []byte) {
+	c.mu.Lock()
+	c.pending = append(c.pending, line)
+	c.evictOverflowLocked()
+	full := len(c.pending) >= c.batchSize
+	c.mu.Unlock()
+
+	if full {
+		select {
+		case c.flushTrigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// evictOverflowLocked drops the oldest pending lines down to
+// maxPendingLines, counting what it drops in droppedPoints. c.mu must
+// be held by the caller.
+func (c *Client) evictOverflowLocked() {
+	if c.maxPendingLines <= 0 {
+		return
+	}
+	if overflow := len(c.pending) - c.maxPendingLines; overflow > 0 {
+		c.droppedPoints += uint64(overflow)
+		c.pending = c.pending[overflow:]
+	}
+}
+
+// Flush blocks until everything currently pending has been written,
+// or ctx is done. It is safe to call concurrently with AddMetric.
+func (c *Client) Flush(ctx context.Context) error {
+	reply := make(chan error, 1)
+
+	select {
+	case c.flushReqCh <- reply:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.closeCh:
+		return fmt.Errorf("influxdbclient: client is closed")
+	}
+
+	select {
+	case err := <-reply:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close flushes any lines still queued for the async writer and stops
+// it. Points buffered by AddMetric since the last PostMetrics call are
+// not written. It is safe to call multiple times.
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() { close(c.closeCh) })
+	c.wg.Wait()
+	return nil
+}
+
+func (c *Client) runAsyncWriter() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.flush(); err != nil {
+				log.Printf("influxdbclient: periodic flush failed: %s", err)
+			}
+		case <-c.flushTrigger:
+			if err := c.flush(); err != nil {
+				log.Printf("influxdbclient: batch flush failed: %s", err)
+			}
+		case reply := <-c.flushReqCh:
+			reply <- c.flush()
+		case <-c.closeCh:
+			if err := c.flush(); err != nil {
+				log.Printf("influxdbclient: final flush failed: %s", err)
+			}
+			return
+		}
+	}
+}
+
+// flush sends everything currently pending. On failure the lines are
+// put back at the front of pending so the next flush retries them,
+// mirroring how postMetricsV1 leaves metricPoints untouched until a
+// successful send; they are only ever discarded via
+// evictOverflowLocked, which accounts for them in droppedPoints.
+func (c *Client) flush() error {
+	c.mu.Lock()
+	lines := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+
+	if len(lines) == 0 {
+		return nil
+	}
+
+	if err := c.send(lines); err != nil {
+		c.requeue(lines)
+		return err
+	}
+
+	c.mu.Lock()
+	c.totalMetricsSent += uint64(len(lines))
+	c.mu.Unlock()
+	return nil
+}
+
+// requeue puts lines that failed to send back at the front of the
+// pending buffer, subject to the same maxPendingLines bound as
+// enqueueLine.
+func (c *Client) requeue(lines [][]byte) {
+	c.mu.Lock()
+	c.pending = append(lines, c.pending...)
+	c.evictOverflowLocked()
+	c.mu.Unlock()
+}
+
+// send POSTs a gzip-compressed batch of line-protocol lines, retrying
+// on 429 and 5xx responses with exponential backoff honoring
+// Retry-After.
+func (c *Client) send(lines [][]byte) error {
+	payload, err := gzipLines(lines)
+	if err != nil {
+		return err
+	}
+
+	wait := retryBaseWait
+	var retryAfter time.Duration
+	var lastErr error
+	for attempt := 0; attempt <= maxWriteAttempts; attempt++ {
+		if attempt > 0 {
+			sleep := jitter(wait)
+			if sleep < retryAfter {
+				sleep = retryAfter
+			}
+			time.Sleep(sleep)
+			wait = nextBackoff(wait)
+		}
+		retryAfter = 0
+
+		req, err := c.newWriteRequestV2(payload)
+		if err != nil {
+			return err
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+
+		lastErr = fmt.Errorf("InfluxDB write returned HTTP response: %s", resp.Status)
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+			return lastErr
+		}
+		if ra := parseRetryAfter(resp.Header.Get("Retry-After")); ra > 0 {
+			wait = ra
+			retryAfter = ra
+		}
+	}
+
+	return lastErr
+}
+
+func (c *Client) newWriteRequestV2(body []byte) (*http.Request, error) {
+	url := fmt.Sprintf("%s/api/v2/write?org=%s&bucket=%s&precision=ns", c.url, c.org, c.bucket)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Token "+c.token)
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("Content-Encoding", "gzip")
+	return req, nil
+}
+
+func gzipLines(lines [][]byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	for _, line := range lines {
+		if _, err := gz.Write(line); err != nil {
+			return nil, err
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// splitLines breaks a newline-joined block of line-protocol output
+// (as produced by formatMetrics) back into individual lines, each
+// still newline-terminated.
+func splitLines(data []byte) [][]byte {
+	raw := bytes.Split(bytes.TrimRight(data, "\n"), []byte("\n"))
+	lines := make([][]byte, 0, len(raw))
+	for _, l := range raw {
+		if len(l) > 0 {
+			lines = append(lines, append(l, '\n'))
+		}
+	}
+	return lines
+}
+
+func nextBackoff(wait time.Duration) time.Duration {
+	wait *= 2
+	if wait > retryMaxWait {
+		wait = retryMaxWait
+	}
+	return wait
+}
+
+// jitter returns a random duration in [wait/2, wait) to avoid
+// thundering-herd retries against a recovering InfluxDB.
+func jitter(wait time.Duration) time.Duration {
+	half := int64(wait / 2)
+	if half <= 0 {
+		return wait
+	}
+	return time.Duration(half) + time.Duration(rand.Int63n(half))
+}
+
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(header); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
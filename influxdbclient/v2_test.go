@@ -0,0 +1,163 @@
+package influxdbclient
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func gunzipBody(t *testing.T, r *http.Request) string {
+	t.Helper()
+	gz, err := gzip.NewReader(r.Body)
+	if err != nil {
+		t.Fatalf("failed to open gzip body: %s", err)
+	}
+	defer gz.Close()
+
+	body, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("failed to read gzip body: %s", err)
+	}
+	return string(body)
+}
+
+func TestV2PostMetricsWritesBufferedPoints(t *testing.T) {
+	var mu sync.Mutex
+	var gotBody string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		gotBody = gunzipBody(t, r)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c := NewV2(srv.URL, "org", "bucket", "token", "", "deployment", "1.2.3.4", 0, time.Hour)
+	defer c.Close()
+
+	c.AddMetric(valueMetricEnvelope("origin", "metric", "job", "deployment", "0", "1.2.3.4", 1000000000, 42))
+
+	if err := c.PostMetrics(); err != nil {
+		t.Fatalf("PostMetrics returned error: %s", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !strings.Contains(gotBody, "origin.metric,") || !strings.Contains(gotBody, "value=42") {
+		t.Errorf("expected the write body to contain the buffered point, got: %q", gotBody)
+	}
+}
+
+func TestV2FlushRetriesOnServerErrorThenSucceeds(t *testing.T) {
+	var attempts int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c := NewV2(srv.URL, "org", "bucket", "token", "", "deployment", "1.2.3.4", 0, time.Hour)
+	defer c.Close()
+
+	c.AddMetric(valueMetricEnvelope("origin", "metric", "job", "deployment", "0", "1.2.3.4", 1000000000, 1))
+
+	if err := c.PostMetrics(); err != nil {
+		t.Fatalf("PostMetrics returned error: %s", err)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 2 {
+		t.Errorf("expected exactly 2 attempts (1 failure + 1 success), got %d", got)
+	}
+}
+
+func TestV2FlushRequeuesLinesOnFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	c := NewV2(srv.URL, "org", "bucket", "token", "", "deployment", "1.2.3.4", 0, time.Hour)
+	defer c.Close()
+
+	c.enqueueLine([]byte("origin.metric,job=job value=1 1\n"))
+
+	if err := c.flush(); err == nil {
+		t.Fatalf("expected flush to return an error for a 400 response")
+	}
+
+	c.mu.Lock()
+	pending := len(c.pending)
+	c.mu.Unlock()
+
+	if pending != 1 {
+		t.Errorf("expected the failed line to be requeued in pending, got %d pending lines", pending)
+	}
+
+	_, droppedPoints := c.counters()
+	if droppedPoints != 0 {
+		t.Errorf("a requeue is not a drop; expected droppedPoints=0, got %d", droppedPoints)
+	}
+}
+
+func TestEvictOverflowLockedBoundsPendingQueue(t *testing.T) {
+	c := NewV2("http://influx", "org", "bucket", "token", "", "deployment", "1.2.3.4", 0, time.Hour)
+	c.SetMaxPendingLines(3)
+
+	c.mu.Lock()
+	for i := 0; i < 5; i++ {
+		c.pending = append(c.pending, []byte("line\n"))
+	}
+	c.evictOverflowLocked()
+	pending := len(c.pending)
+	c.pending = nil
+	c.mu.Unlock()
+
+	if pending != 3 {
+		t.Errorf("expected pending to be capped at maxPendingLines=3, got %d", pending)
+	}
+
+	_, droppedPoints := c.counters()
+	if droppedPoints != 2 {
+		t.Errorf("expected droppedPoints=2 for the 2 evicted lines, got %d", droppedPoints)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close returned error: %s", err)
+	}
+}
+
+func TestCloseFlushesPendingLines(t *testing.T) {
+	received := make(chan struct{}, 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+		select {
+		case received <- struct{}{}:
+		default:
+		}
+	}))
+	defer srv.Close()
+
+	c := NewV2(srv.URL, "org", "bucket", "token", "", "deployment", "1.2.3.4", 0, time.Hour)
+	c.enqueueLine([]byte("origin.metric,job=job value=1 1\n"))
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close returned error: %s", err)
+	}
+
+	select {
+	case <-received:
+	default:
+		t.Errorf("expected Close to flush the pending line to the server")
+	}
+}
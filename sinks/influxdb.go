@@ -0,0 +1,31 @@
+package sinks
+
+import (
+	"github.com/cloudfoundry/sonde-go/events"
+
+	"github.com/18F/influxdb-firehose-nozzle/influxdbclient"
+)
+
+// InfluxDBSink adapts an influxdbclient.Client to the Sink interface.
+// This is the nozzle's original, and still default, backend.
+type InfluxDBSink struct {
+	client *influxdbclient.Client
+}
+
+func NewInfluxDBSink(client *influxdbclient.Client) *InfluxDBSink {
+	return &InfluxDBSink{client: client}
+}
+
+func (s *InfluxDBSink) AddMetric(envelope *events.Envelope) {
+	s.client.AddMetric(envelope)
+}
+
+func (s *InfluxDBSink) PostMetrics() error {
+	return s.client.PostMetrics()
+}
+
+func (s *InfluxDBSink) AlertSlowConsumerError() {
+	s.client.AlertSlowConsumerError()
+}
+
+var _ Sink = (*InfluxDBSink)(nil)
@@ -0,0 +1,41 @@
+package sinks
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/golang/protobuf/proto"
+
+	"github.com/18F/influxdb-firehose-nozzle/influxdbclient"
+)
+
+func TestInfluxDBSinkDelegatesToClient(t *testing.T) {
+	var posted bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted = true
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	client := influxdbclient.New(srv.URL, "db", "", "", "", "deployment", "1.2.3.4")
+	s := NewInfluxDBSink(client)
+
+	s.AddMetric(&events.Envelope{
+		Origin:    proto.String("origin"),
+		EventType: events.Envelope_ValueMetric.Enum(),
+		ValueMetric: &events.ValueMetric{
+			Name:  proto.String("metric"),
+			Value: proto.Float64(1),
+		},
+	})
+	s.AlertSlowConsumerError()
+
+	if err := s.PostMetrics(); err != nil {
+		t.Fatalf("PostMetrics returned error: %s", err)
+	}
+	if !posted {
+		t.Errorf("expected AddMetric/PostMetrics to reach the underlying InfluxDB client")
+	}
+}
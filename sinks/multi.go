@@ -0,0 +1,52 @@
+package sinks
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+// MultiSink fans every envelope out to a fixed list of child sinks,
+// so operators can dual-write to InfluxDB while scraping Prometheus,
+// or add a stdout sink for debugging without giving up either.
+type MultiSink struct {
+	Sinks []Sink
+}
+
+// NewMultiSink builds a MultiSink over the given children. Children
+// are driven in the order given.
+func NewMultiSink(children ...Sink) *MultiSink {
+	return &MultiSink{Sinks: children}
+}
+
+func (m *MultiSink) AddMetric(envelope *events.Envelope) {
+	for _, sink := range m.Sinks {
+		sink.AddMetric(envelope)
+	}
+}
+
+func (m *MultiSink) AlertSlowConsumerError() {
+	for _, sink := range m.Sinks {
+		sink.AlertSlowConsumerError()
+	}
+}
+
+// PostMetrics flushes every child sink. A failing child does not stop
+// the others from being posted to; any errors are aggregated into a
+// single returned error.
+func (m *MultiSink) PostMetrics() error {
+	var errs []string
+	for _, sink := range m.Sinks {
+		if err := sink.PostMetrics(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d sink(s) failed to post metrics: %s", len(errs), len(m.Sinks), strings.Join(errs, "; "))
+}
+
+var _ Sink = (*MultiSink)(nil)
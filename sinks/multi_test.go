@@ -0,0 +1,60 @@
+package sinks
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+type fakeSink struct {
+	addMetricCalls   int
+	postMetricsErr   error
+	postMetricsCalls int
+	alertCalls       int
+}
+
+func (f *fakeSink) AddMetric(envelope *events.Envelope) { f.addMetricCalls++ }
+func (f *fakeSink) PostMetrics() error {
+	f.postMetricsCalls++
+	return f.postMetricsErr
+}
+func (f *fakeSink) AlertSlowConsumerError() { f.alertCalls++ }
+
+func TestMultiSinkFansOutToEveryChild(t *testing.T) {
+	a, b := &fakeSink{}, &fakeSink{}
+	m := NewMultiSink(a, b)
+
+	m.AddMetric(&events.Envelope{})
+	m.AlertSlowConsumerError()
+
+	if a.addMetricCalls != 1 || b.addMetricCalls != 1 {
+		t.Errorf("expected both children to see AddMetric, got a=%d b=%d", a.addMetricCalls, b.addMetricCalls)
+	}
+	if a.alertCalls != 1 || b.alertCalls != 1 {
+		t.Errorf("expected both children to see AlertSlowConsumerError, got a=%d b=%d", a.alertCalls, b.alertCalls)
+	}
+}
+
+func TestMultiSinkPostMetricsAggregatesErrors(t *testing.T) {
+	ok := &fakeSink{}
+	failing := &fakeSink{postMetricsErr: errors.New("boom")}
+	m := NewMultiSink(ok, failing)
+
+	err := m.PostMetrics()
+	if err == nil {
+		t.Fatalf("expected an aggregated error, got nil")
+	}
+	if ok.postMetricsCalls != 1 || failing.postMetricsCalls != 1 {
+		t.Errorf("expected PostMetrics to be called on every child regardless of earlier failures, got ok=%d failing=%d", ok.postMetricsCalls, failing.postMetricsCalls)
+	}
+}
+
+func TestMultiSinkPostMetricsNilWhenAllSucceed(t *testing.T) {
+	a, b := &fakeSink{}, &fakeSink{}
+	m := NewMultiSink(a, b)
+
+	if err := m.PostMetrics(); err != nil {
+		t.Errorf("expected nil error when every child succeeds, got %s", err)
+	}
+}
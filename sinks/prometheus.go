@@ -0,0 +1,152 @@
+package sinks
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// labelNames is the tag set every gauge is registered with, so that
+// regular metrics and container metrics land in the same vectors;
+// unused labels are left as the empty string.
+var labelNames = []string{"deployment", "job", "index", "ip", "application_id", "instance_index"}
+
+// PrometheusSink exposes firehose metrics as a /metrics handler
+// backed by its own registry, so operators can scrape the nozzle
+// directly instead of (or alongside) writing to InfluxDB.
+type PrometheusSink struct {
+	registry           *prometheus.Registry
+	slowConsumerAlerts prometheus.Counter
+
+	mu     sync.Mutex
+	gauges map[string]*prometheus.GaugeVec
+}
+
+func NewPrometheusSink() *PrometheusSink {
+	registry := prometheus.NewRegistry()
+
+	slowConsumerAlerts := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "firehose_slow_consumer_alerts_total",
+		Help: "Number of times the nozzle reported itself as a slow consumer.",
+	})
+	registry.MustRegister(slowConsumerAlerts)
+
+	return &PrometheusSink{
+		registry:           registry,
+		slowConsumerAlerts: slowConsumerAlerts,
+		gauges:             make(map[string]*prometheus.GaugeVec),
+	}
+}
+
+// Handler returns the http.Handler to mount at /metrics.
+func (s *PrometheusSink) Handler() http.Handler {
+	return promhttp.HandlerFor(s.registry, promhttp.HandlerOpts{})
+}
+
+func (s *PrometheusSink) AddMetric(envelope *events.Envelope) {
+	switch envelope.GetEventType() {
+	case events.Envelope_ValueMetric:
+		name := envelope.GetOrigin() + "." + envelope.GetValueMetric().GetName()
+		s.set(name, envelope, "", 0, envelope.GetValueMetric().GetValue())
+	case events.Envelope_CounterEvent:
+		name := envelope.GetOrigin() + "." + envelope.GetCounterEvent().GetName()
+		s.set(name, envelope, "", 0, float64(envelope.GetCounterEvent().GetTotal()))
+	case events.Envelope_ContainerMetric:
+		s.addContainerMetric(envelope)
+	}
+}
+
+func (s *PrometheusSink) addContainerMetric(envelope *events.Envelope) {
+	cm := envelope.GetContainerMetric()
+	applicationId := cm.GetApplicationId()
+	instanceIndex := cm.GetInstanceIndex()
+
+	stats := map[string]float64{
+		"cpu_percentage":     cm.GetCpuPercentage(),
+		"memory_bytes":       float64(cm.GetMemoryBytes()),
+		"disk_bytes":         float64(cm.GetDiskBytes()),
+		"memory_bytes_quota": float64(cm.GetMemoryBytesQuota()),
+		"disk_bytes_quota":   float64(cm.GetDiskBytesQuota()),
+	}
+
+	for name, value := range stats {
+		s.set(name, envelope, applicationId, instanceIndex, value)
+	}
+}
+
+func (s *PrometheusSink) set(name string, envelope *events.Envelope, applicationId string, instanceIndex int32, value float64) {
+	labels := prometheus.Labels{
+		"deployment":     envelope.GetDeployment(),
+		"job":            envelope.GetJob(),
+		"index":          envelope.GetIndex(),
+		"ip":             envelope.GetIp(),
+		"application_id": applicationId,
+		"instance_index": instanceIndexLabel(applicationId, instanceIndex),
+	}
+	s.gaugeFor(name).With(labels).Set(value)
+}
+
+// gaugeFor returns the GaugeVec registered under name's sanitized
+// form, creating it on first use. The cache is keyed by the
+// sanitized name rather than the raw one, since two different raw
+// names (e.g. a ValueMetric "cpu.percentage" and the container stat
+// "cpu_percentage") can sanitize to the same Prometheus name; the
+// first raw name to arrive wins the gauge and later colliding names
+// are folded into it rather than attempting a second, conflicting
+// registration.
+func (s *PrometheusSink) gaugeFor(name string) *prometheus.GaugeVec {
+	sanitized := sanitizeMetricName(name)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	gauge, ok := s.gauges[sanitized]
+	if !ok {
+		gauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: sanitized,
+			Help: "Firehose metric " + name,
+		}, labelNames)
+		s.registry.MustRegister(gauge)
+		s.gauges[sanitized] = gauge
+	}
+	return gauge
+}
+
+// PostMetrics is a no-op: Prometheus metrics are scraped through
+// Handler, not pushed.
+func (s *PrometheusSink) PostMetrics() error {
+	return nil
+}
+
+func (s *PrometheusSink) AlertSlowConsumerError() {
+	s.slowConsumerAlerts.Inc()
+}
+
+func instanceIndexLabel(applicationId string, instanceIndex int32) string {
+	if applicationId == "" {
+		return ""
+	}
+	return strconv.Itoa(int(instanceIndex))
+}
+
+// sanitizeMetricName replaces anything that isn't a valid Prometheus
+// metric name character with an underscore, since firehose origins
+// use dots (e.g. "rep.CapacityRemainingMemory").
+func sanitizeMetricName(name string) string {
+	out := make([]rune, 0, len(name))
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == ':':
+			out = append(out, r)
+		default:
+			out = append(out, '_')
+		}
+	}
+	return string(out)
+}
+
+var _ Sink = (*PrometheusSink)(nil)
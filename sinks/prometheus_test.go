@@ -0,0 +1,75 @@
+package sinks
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestPrometheusSinkHandlerServesMetrics(t *testing.T) {
+	s := NewPrometheusSink()
+	s.AddMetric(&events.Envelope{
+		Origin:    proto.String("rep"),
+		EventType: events.Envelope_ValueMetric.Enum(),
+		ValueMetric: &events.ValueMetric{
+			Name:  proto.String("CapacityRemainingMemory"),
+			Value: proto.Float64(1),
+		},
+	})
+	s.AlertSlowConsumerError()
+
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatalf("GET /metrics failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		t.Errorf("expected 200 from the metrics handler, got %d", resp.StatusCode)
+	}
+}
+
+// TestPrometheusSinkCollidingNamesDoNotPanic reproduces the bug where a
+// ValueMetric origin/name pair and a container stat name that sanitize
+// to the same Prometheus metric name used to register two conflicting
+// GaugeVecs and panic on the second MustRegister call.
+func TestPrometheusSinkCollidingNamesDoNotPanic(t *testing.T) {
+	s := NewPrometheusSink()
+
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("AddMetric panicked on colliding sanitized names: %v", r)
+		}
+	}()
+
+	s.AddMetric(&events.Envelope{
+		Origin:    proto.String("cpu"),
+		EventType: events.Envelope_ValueMetric.Enum(),
+		ValueMetric: &events.ValueMetric{
+			Name:  proto.String("percentage"),
+			Value: proto.Float64(1),
+		},
+	})
+
+	s.AddMetric(&events.Envelope{
+		EventType: events.Envelope_ContainerMetric.Enum(),
+		ContainerMetric: &events.ContainerMetric{
+			ApplicationId: proto.String("app-1"),
+			InstanceIndex: proto.Int32(0),
+			CpuPercentage: proto.Float64(2),
+		},
+	})
+
+	// One ValueMetric (cpu.percentage) plus five container stats, one of
+	// which (cpu_percentage) sanitizes to the same name as the
+	// ValueMetric above and so should share its gauge instead of
+	// registering a second, colliding one: 5 distinct gauges, not 6.
+	if len(s.gauges) != 5 {
+		t.Errorf("expected the colliding cpu.percentage/cpu_percentage names to share a gauge (5 total), got %d cached gauges", len(s.gauges))
+	}
+}
@@ -0,0 +1,17 @@
+// Package sinks defines the interface the nozzle uses to fan firehose
+// envelopes out to one or more metric backends, plus a handful of
+// implementations: InfluxDB (the original, still-default backend),
+// Prometheus, and stdout/JSON for local debugging.
+package sinks
+
+import "github.com/cloudfoundry/sonde-go/events"
+
+// Sink receives firehose envelopes and periodically ships whatever it
+// has accumulated to its backend. Implementations are not expected to
+// be safe for concurrent use; the nozzle drives a single Sink (or
+// MultiSink) from one goroutine.
+type Sink interface {
+	AddMetric(envelope *events.Envelope)
+	PostMetrics() error
+	AlertSlowConsumerError()
+}
@@ -0,0 +1,38 @@
+package sinks
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+// StdoutSink writes every envelope it sees to stdout as JSON. It's
+// meant for local debugging: confirming the nozzle is actually
+// receiving what you expect before trusting a real backend.
+type StdoutSink struct {
+	out *json.Encoder
+}
+
+func NewStdoutSink() *StdoutSink {
+	return &StdoutSink{out: json.NewEncoder(os.Stdout)}
+}
+
+func (s *StdoutSink) AddMetric(envelope *events.Envelope) {
+	if err := s.out.Encode(envelope); err != nil {
+		fmt.Fprintf(os.Stderr, "stdout sink: failed to encode envelope: %s\n", err)
+	}
+}
+
+// PostMetrics is a no-op: the stdout sink writes each envelope as it
+// arrives, so there is nothing buffered to flush.
+func (s *StdoutSink) PostMetrics() error {
+	return nil
+}
+
+func (s *StdoutSink) AlertSlowConsumerError() {
+	fmt.Fprintln(os.Stderr, "stdout sink: slow consumer alert")
+}
+
+var _ Sink = (*StdoutSink)(nil)
@@ -0,0 +1,26 @@
+package sinks
+
+import (
+	"testing"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestStdoutSinkDoesNotPanic(t *testing.T) {
+	s := NewStdoutSink()
+
+	s.AddMetric(&events.Envelope{
+		Origin:    proto.String("origin"),
+		EventType: events.Envelope_ValueMetric.Enum(),
+		ValueMetric: &events.ValueMetric{
+			Name:  proto.String("metric"),
+			Value: proto.Float64(1),
+		},
+	})
+	s.AlertSlowConsumerError()
+
+	if err := s.PostMetrics(); err != nil {
+		t.Errorf("expected PostMetrics to be a no-op, got error %s", err)
+	}
+}